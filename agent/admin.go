@@ -0,0 +1,199 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/pico/agent/config"
+	"github.com/andydunstall/pico/agent/metrics"
+	"github.com/andydunstall/pico/pkg/log"
+)
+
+// AdminServer is a local-only HTTP server exposing operational endpoints
+// for a running agent:
+//
+//   - GET /agent/monitor?level=debug streams the agent's live log output.
+//   - GET /agent/listeners lists the currently registered listeners.
+//   - POST /agent/listeners registers a new listener from a JSON
+//     ListenerConfig body, used by 'pico agent listeners add'.
+//   - DELETE /agent/listeners?endpoint_id=<id> stops a listener, used by
+//     'pico agent listeners remove'.
+//   - GET /metrics exposes Prometheus metrics for forwarded requests.
+//   - GET /agent/healthz reports whether the agent is healthy.
+type AdminServer struct {
+	addr   string
+	agent  *Agent
+	logger *log.Logger
+
+	server *http.Server
+}
+
+// NewAdminServer creates an admin server that will listen on addr.
+func NewAdminServer(addr string, agent *Agent, logger *log.Logger) *AdminServer {
+	s := &AdminServer{
+		addr:   addr,
+		agent:  agent,
+		logger: logger.WithSubsystem("agent.admin"),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/agent/monitor", s.monitorHandler)
+	mux.HandleFunc("/agent/listeners", s.listenersHandler)
+	mux.HandleFunc("/agent/healthz", s.healthzHandler)
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	return s
+}
+
+// Run starts the admin server and blocks until ctx is cancelled.
+func (s *AdminServer) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("starting admin server", zap.String("addr", s.addr))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.server.Serve(ln)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return s.server.Shutdown(context.Background())
+	case err := <-errCh:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+}
+
+// monitorHandler streams the agent's live log output to the client until
+// the request is cancelled, such as when the client disconnects or the
+// agent shuts down.
+func (s *AdminServer) monitorHandler(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	if level == "" {
+		level = "info"
+	}
+
+	sink, err := s.logger.AddSink(level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer sink.Close()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-sink.C():
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+type listenerStatus struct {
+	EndpointID string `json:"endpoint_id"`
+	Addr       string `json:"addr"`
+	Protocol   string `json:"protocol"`
+}
+
+func (s *AdminServer) listenersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listListeners(w, r)
+	case http.MethodPost:
+		s.addListener(w, r)
+	case http.MethodDelete:
+		s.removeListener(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *AdminServer) listListeners(w http.ResponseWriter, r *http.Request) {
+	listeners := s.agent.ListenerConfigs()
+
+	statuses := make([]listenerStatus, 0, len(listeners))
+	for _, lc := range listeners {
+		statuses = append(statuses, listenerStatus{
+			EndpointID: lc.EndpointID,
+			Addr:       lc.Addr,
+			Protocol:   lc.Protocol,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+func (s *AdminServer) addListener(w http.ResponseWriter, r *http.Request) {
+	var lc config.ListenerConfig
+	if err := json.NewDecoder(r.Body).Decode(&lc); err != nil {
+		http.Error(w, fmt.Sprintf("decode listener config: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := lc.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.agent.AddListener(lc); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *AdminServer) removeListener(w http.ResponseWriter, r *http.Request) {
+	endpointID := r.URL.Query().Get("endpoint_id")
+	if endpointID == "" {
+		http.Error(w, "missing endpoint_id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.agent.RemoveListener(endpointID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *AdminServer) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+// Package agent implements the Pico agent, which registers listeners with
+// a Pico server and forwards incoming requests to upstream services.
+package agent
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/pico/agent/config"
+	"github.com/andydunstall/pico/pkg/log"
+)
+
+// managedListener tracks the lifecycle of a single running listener so it
+// can be stopped independently when the agent is reconciled.
+type managedListener struct {
+	listener listenerRunner
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	// adminManaged is true for a listener started via AddListener (such as
+	// from 'pico agent listeners add') rather than from the configuration
+	// Reconcile was called with. Reconcile leaves these running even though
+	// they're absent from conf, since otherwise the next unrelated hot
+	// reload would tear them down as "no longer configured".
+	adminManaged bool
+}
+
+// Agent manages the set of listeners registered with the Pico server,
+// supporting adding, removing and restarting listeners at runtime as the
+// configuration changes (such as on a hot reload).
+type Agent struct {
+	mu        sync.Mutex
+	listeners map[string]*managedListener
+	// ctx is the parent context new listeners are started with, set by
+	// each call to Reconcile. AddListener reuses it so a listener added
+	// later (such as via the admin API) still stops when the agent does.
+	ctx context.Context
+
+	// serverURL is the Pico server listeners register with, set by each
+	// call to Reconcile. AddListener reuses it for the same reason as ctx.
+	serverURL string
+
+	logger *log.Logger
+}
+
+// NewAgent creates an agent with no listeners registered. Call Reconcile to
+// start listeners from a configuration.
+func NewAgent(logger *log.Logger) *Agent {
+	return &Agent{
+		listeners: make(map[string]*managedListener),
+		logger:    logger.WithSubsystem("agent"),
+	}
+}
+
+// Reconcile updates the running listeners to match conf: listeners that are
+// new are started, listeners that are no longer configured are stopped, and
+// listeners whose configuration changed are restarted. Unrelated listeners
+// are left untouched so their connections are not dropped.
+func (a *Agent) Reconcile(ctx context.Context, conf *config.Config) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.ctx = ctx
+	a.serverURL = conf.Server.URL
+
+	wanted := make(map[string]config.ListenerConfig, len(conf.ListenerConfigs))
+	for _, lc := range conf.ListenerConfigs {
+		wanted[lc.EndpointID] = lc
+	}
+
+	// Stop listeners that are no longer configured, or whose configuration
+	// has changed (which will be started again below). Admin-managed
+	// listeners are left alone even if conf doesn't mention them, since they
+	// weren't started from conf in the first place.
+	for id, ml := range a.listeners {
+		if ml.adminManaged {
+			continue
+		}
+		lc, ok := wanted[id]
+		if !ok {
+			a.logger.Info("removing listener", zap.String("endpoint-id", id))
+			a.stopLocked(ml)
+			delete(a.listeners, id)
+			continue
+		}
+		if !reflect.DeepEqual(lc, ml.listener.Config()) {
+			a.logger.Info("restarting changed listener", zap.String("endpoint-id", id))
+			a.stopLocked(ml)
+			delete(a.listeners, id)
+		}
+	}
+
+	// Start any listeners that aren't already running. Keep going on error
+	// so one bad listener doesn't stop the rest from being reconciled.
+	var firstErr error
+	for id, lc := range wanted {
+		if _, ok := a.listeners[id]; ok {
+			continue
+		}
+		a.logger.Info("starting listener", zap.String("endpoint-id", id))
+		if err := a.startLocked(ctx, lc, false); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (a *Agent) startLocked(ctx context.Context, lc config.ListenerConfig, adminManaged bool) error {
+	listener, err := newRunner(lc, a.serverURL, a.logger)
+	if err != nil {
+		return fmt.Errorf("endpoint %s: %w", lc.EndpointID, err)
+	}
+
+	lCtx, cancel := context.WithCancel(ctx)
+	ml := &managedListener{
+		listener:     listener,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		adminManaged: adminManaged,
+	}
+	go func() {
+		defer close(ml.done)
+		if err := listener.Run(lCtx); err != nil {
+			a.logger.Error(
+				"listener exited",
+				zap.String("endpoint-id", lc.EndpointID),
+				zap.Error(err),
+			)
+		}
+	}()
+	a.listeners[lc.EndpointID] = ml
+	return nil
+}
+
+// AddListener starts a new listener from lc, such as from 'pico agent
+// listeners add'. It returns an error if a listener with the same endpoint
+// ID is already registered.
+func (a *Agent) AddListener(lc config.ListenerConfig) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.listeners[lc.EndpointID]; ok {
+		return fmt.Errorf("listener already registered: %s", lc.EndpointID)
+	}
+	if a.ctx == nil {
+		return fmt.Errorf("agent not running")
+	}
+	return a.startLocked(a.ctx, lc, true)
+}
+
+// RemoveListener stops the listener registered for endpointID, such as
+// from 'pico agent listeners remove'. It returns an error if no such
+// listener is registered.
+func (a *Agent) RemoveListener(endpointID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ml, ok := a.listeners[endpointID]
+	if !ok {
+		return fmt.Errorf("listener not registered: %s", endpointID)
+	}
+	a.stopLocked(ml)
+	delete(a.listeners, endpointID)
+	return nil
+}
+
+func (a *Agent) stopLocked(ml *managedListener) {
+	ml.cancel()
+	<-ml.done
+}
+
+// Listeners returns the endpoint IDs of the currently running listeners.
+func (a *Agent) Listeners() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ids := make([]string, 0, len(a.listeners))
+	for id := range a.listeners {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// ListenerConfigs returns the configuration of each currently running
+// listener.
+func (a *Agent) ListenerConfigs() []config.ListenerConfig {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	configs := make([]config.ListenerConfig, 0, len(a.listeners))
+	for _, ml := range a.listeners {
+		configs = append(configs, ml.listener.Config())
+	}
+	return configs
+}
+
+// Close stops all running listeners.
+func (a *Agent) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, ml := range a.listeners {
+		a.stopLocked(ml)
+		delete(a.listeners, id)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andydunstall/pico/agent/config"
+	"github.com/andydunstall/pico/pkg/log"
+)
+
+func testLogger(t *testing.T) *log.Logger {
+	logger, err := log.NewLogger("error", nil)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+	return logger
+}
+
+// TestReconcileLeavesAdminAddedListener verifies a listener registered via
+// AddListener (such as from 'pico agent listeners add') survives a
+// Reconcile triggered by an unrelated config change, rather than being torn
+// down as "no longer configured".
+func TestReconcileLeavesAdminAddedListener(t *testing.T) {
+	a := NewAgent(testLogger(t))
+	defer a.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf := &config.Config{
+		ListenerConfigs: []config.ListenerConfig{
+			{EndpointID: "from-conf", Addr: "localhost:0", Protocol: "tcp"},
+		},
+	}
+	if err := a.Reconcile(ctx, conf); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	if err := a.AddListener(config.ListenerConfig{
+		EndpointID: "admin-added",
+		Addr:       "localhost:0",
+		Protocol:   "tcp",
+	}); err != nil {
+		t.Fatalf("add listener: %v", err)
+	}
+
+	// Reconcile again with the same conf, simulating an unrelated hot
+	// reload or SIGHUP.
+	if err := a.Reconcile(ctx, conf); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	found := false
+	for _, id := range a.Listeners() {
+		if id == "admin-added" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected admin-added listener to survive reconcile")
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+// Package config defines the agent configuration, which may be loaded from
+// a YAML file, CLI flags, or both (with CLI flags taking precedence).
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ListenerConfig describes a single registered listener.
+type ListenerConfig struct {
+	// EndpointID is the ID of the endpoint to register with the Pico server.
+	EndpointID string `yaml:"endpoint_id" json:"endpoint_id"`
+
+	// Addr is the address of the upstream service to forward requests to.
+	Addr string `yaml:"addr" json:"addr"`
+
+	// Protocol is the upstream protocol to use when forwarding requests.
+	// Defaults to "http".
+	Protocol string `yaml:"protocol" json:"protocol"`
+
+	// TLS contains the TLS configuration to use when dialing the upstream,
+	// if any.
+	TLS *TLSConfig `yaml:"tls" json:"tls"`
+
+	// DialTimeout is the timeout when dialing the upstream service.
+	DialTimeout time.Duration `yaml:"dial_timeout" json:"dial_timeout"`
+
+	// HealthCheckPath is the upstream's health check path. It is only read
+	// from the configuration; the agent does not poll it and registers the
+	// listener regardless of the upstream's health.
+	HealthCheckPath string `yaml:"health_check_path" json:"health_check_path"`
+
+	// AuthToken, if set, is sent in the 'Piko-Auth-Token' header when
+	// registering this listener with the Pico server, and on each upload
+	// for the "file-sweep" protocol.
+	AuthToken string `yaml:"auth_token" json:"auth_token"`
+
+	// RewriteHost overrides the 'Host' header of requests forwarded to an
+	// HTTP(S) upstream. Only used when Protocol is "http" or "https".
+	RewriteHost string `yaml:"rewrite_host" json:"rewrite_host"`
+
+	// Headers are injected into requests forwarded to an HTTP(S) upstream,
+	// overriding any header of the same name set by the client. Only used
+	// when Protocol is "http" or "https".
+	Headers map[string]string `yaml:"headers" json:"headers"`
+
+	// GRPCServices lists the gRPC services registered on this listener,
+	// used to route a single endpoint across multiple upstream gRPC
+	// services by method prefix. Only used when Protocol is "grpc".
+	GRPCServices []GRPCServiceConfig `yaml:"grpc_services" json:"grpc_services"`
+
+	// SweepDir is the local directory swept for files to upload. Only used
+	// when Protocol is "file-sweep".
+	SweepDir string `yaml:"sweep_dir" json:"sweep_dir"`
+
+	// SweepInterval is how often SweepDir is scanned for new files.
+	// Defaults to 30s. Only used when Protocol is "file-sweep".
+	SweepInterval time.Duration `yaml:"sweep_interval" json:"sweep_interval"`
+
+	// SweepConcurrency is the number of files uploaded concurrently.
+	// Defaults to 10. Only used when Protocol is "file-sweep".
+	SweepConcurrency int `yaml:"sweep_concurrency" json:"sweep_concurrency"`
+}
+
+// GRPCServiceConfig routes a gRPC service to a distinct upstream address,
+// allowing a single listener to multiplex multiple services.
+type GRPCServiceConfig struct {
+	// Service is the fully qualified gRPC service name, such as
+	// 'myapp.v1.UserService'.
+	Service string `yaml:"service" json:"service"`
+
+	// Addr is the upstream address serving this service.
+	Addr string `yaml:"addr" json:"addr"`
+}
+
+// validProtocols are the upstream protocols the agent knows how to forward
+// to. Keep in sync with the switch in agent/upstream.New.
+var validProtocols = map[string]struct{}{
+	"http":       {},
+	"https":      {},
+	"tcp":        {},
+	"unix":       {},
+	"grpc":       {},
+	"file-sweep": {},
+}
+
+func (c *ListenerConfig) Validate() error {
+	if c.EndpointID == "" {
+		return fmt.Errorf("missing endpoint id")
+	}
+	if c.Protocol == "" {
+		c.Protocol = "http"
+	}
+	if _, ok := validProtocols[c.Protocol]; !ok {
+		return fmt.Errorf("unknown protocol: %s", c.Protocol)
+	}
+
+	if c.Protocol == "file-sweep" {
+		if c.SweepDir == "" {
+			return fmt.Errorf("missing sweep dir")
+		}
+		if c.Addr == "" {
+			return fmt.Errorf("missing addr")
+		}
+		if c.SweepInterval == 0 {
+			c.SweepInterval = 30 * time.Second
+		}
+		if c.SweepConcurrency == 0 {
+			c.SweepConcurrency = 10
+		}
+		return nil
+	}
+
+	if c.Addr == "" {
+		return fmt.Errorf("missing addr")
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 10 * time.Second
+	}
+	return nil
+}
+
+// TLSConfig configures TLS when dialing the upstream.
+type TLSConfig struct {
+	Cert               string `yaml:"cert" json:"cert"`
+	Key                string `yaml:"key" json:"key"`
+	RootCAs            string `yaml:"root_cas" json:"root_cas"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+}
+
+type LogConfig struct {
+	Level      string   `yaml:"level" json:"level"`
+	Subsystems []string `yaml:"subsystems" json:"subsystems"`
+}
+
+// ServerConfig configures the connection to the Pico server.
+type ServerConfig struct {
+	// URL is the address of the Pico server to register listeners with,
+	// such as 'https://pico.example.com'. Required unless every listener
+	// uses the "file-sweep" protocol.
+	URL string `yaml:"url" json:"url"`
+}
+
+// AdminConfig configures the agent's local admin HTTP server, which exposes
+// operational endpoints such as '/agent/monitor', '/metrics' and
+// '/agent/healthz'.
+type AdminConfig struct {
+	// BindAddr is the address the admin server listens on. Defaults to
+	// '127.0.0.1:9901', and should not be exposed beyond localhost.
+	BindAddr string `yaml:"bind_addr" json:"bind_addr"`
+}
+
+// TelemetryConfig configures OpenTelemetry tracing for forwarded requests.
+// Tracing is disabled unless OTLPEndpoint is set.
+type TelemetryConfig struct {
+	// OTLPEndpoint is the OTLP/gRPC endpoint to export traces to, such as
+	// 'localhost:4317'. Tracing is disabled if empty.
+	OTLPEndpoint string `yaml:"otlp_endpoint" json:"otlp_endpoint"`
+
+	// SamplerRatio is the fraction of traces to sample, between 0 and 1.
+	// Defaults to 1 (sample everything).
+	SamplerRatio float64 `yaml:"sampler_ratio" json:"sampler_ratio"`
+
+	// ServiceName identifies this agent in exported traces. Defaults to
+	// 'pico-agent'.
+	ServiceName string `yaml:"service_name" json:"service_name"`
+}
+
+// Config is the agent configuration.
+type Config struct {
+	// ConfigFile is the path to the YAML configuration file, if any. Values
+	// in the file are overridden by any CLI flags explicitly set.
+	ConfigFile string `yaml:"-" json:"-"`
+
+	// Listeners is the set of listeners to register, in the legacy
+	// '<endpoint ID>/<forward addr>' format, as set by the '--listeners'
+	// flag.
+	//
+	// Deprecated: use ListenerConfigs (populated from the 'listeners' key
+	// in the YAML file) instead.
+	Listeners []string `yaml:"-" json:"-"`
+
+	// ListenerConfigs is the set of listeners to register, as loaded from
+	// the YAML configuration file.
+	ListenerConfigs []ListenerConfig `yaml:"listeners" json:"listeners"`
+
+	Server ServerConfig `yaml:"server" json:"server"`
+
+	Log LogConfig `yaml:"log" json:"log"`
+
+	Admin AdminConfig `yaml:"admin" json:"admin"`
+
+	Telemetry TelemetryConfig `yaml:"telemetry" json:"telemetry"`
+}
+
+// Load reads and parses the YAML configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var conf Config
+	if err := yaml.Unmarshal(b, &conf); err != nil {
+		return nil, fmt.Errorf("parse config file: %w", err)
+	}
+	conf.ConfigFile = path
+	return &conf, nil
+}
+
+// Merge overrides fields in c with any non-zero fields explicitly set on
+// the command line via flags, so CLI flags take precedence over the file.
+func (c *Config) Merge(flags *Config) {
+	if len(flags.Listeners) > 0 {
+		c.Listeners = flags.Listeners
+	}
+	if flags.Log.Level != "" {
+		c.Log.Level = flags.Log.Level
+	}
+	if len(flags.Log.Subsystems) > 0 {
+		c.Log.Subsystems = flags.Log.Subsystems
+	}
+	if flags.Server.URL != "" {
+		c.Server.URL = flags.Server.URL
+	}
+	if flags.Admin.BindAddr != "" {
+		c.Admin.BindAddr = flags.Admin.BindAddr
+	}
+	if flags.Telemetry.OTLPEndpoint != "" {
+		c.Telemetry.OTLPEndpoint = flags.Telemetry.OTLPEndpoint
+	}
+}
+
+// Validate parses the legacy '--listeners' flag format into
+// ListenerConfigs, then validates all listener configuration.
+func (c *Config) Validate() error {
+	for _, l := range c.Listeners {
+		lc, err := parseLegacyListener(l)
+		if err != nil {
+			return err
+		}
+		c.ListenerConfigs = append(c.ListenerConfigs, lc)
+	}
+
+	if len(c.ListenerConfigs) == 0 {
+		return fmt.Errorf("no listeners configured")
+	}
+
+	seen := make(map[string]struct{})
+	needsServer := false
+	for i := range c.ListenerConfigs {
+		if err := c.ListenerConfigs[i].Validate(); err != nil {
+			return fmt.Errorf("listener %s: %w", c.ListenerConfigs[i].EndpointID, err)
+		}
+		if _, ok := seen[c.ListenerConfigs[i].EndpointID]; ok {
+			return fmt.Errorf("duplicate endpoint id: %s", c.ListenerConfigs[i].EndpointID)
+		}
+		seen[c.ListenerConfigs[i].EndpointID] = struct{}{}
+		if c.ListenerConfigs[i].Protocol != "file-sweep" {
+			needsServer = true
+		}
+	}
+
+	if needsServer && c.Server.URL == "" {
+		return fmt.Errorf("missing server.url")
+	}
+
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+
+	if c.Admin.BindAddr == "" {
+		c.Admin.BindAddr = "127.0.0.1:9901"
+	}
+
+	if c.Telemetry.SamplerRatio == 0 {
+		c.Telemetry.SamplerRatio = 1
+	}
+	if c.Telemetry.ServiceName == "" {
+		c.Telemetry.ServiceName = "pico-agent"
+	}
+
+	return nil
+}
+
+func parseLegacyListener(s string) (ListenerConfig, error) {
+	endpointID, addr, ok := splitOnce(s, '/')
+	if !ok {
+		return ListenerConfig{}, fmt.Errorf("invalid listener %q: must have format '<endpoint ID>/<forward addr>'", s)
+	}
+	return ListenerConfig{
+		EndpointID: endpointID,
+		Addr:       addr,
+		Protocol:   "http",
+	}, nil
+}
+
+func splitOnce(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == sep {
+			return s[:i], s[i+1:], true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package config
+
+import "testing"
+
+func TestListenerConfigValidateFileSweepRequiresAddr(t *testing.T) {
+	lc := ListenerConfig{
+		EndpointID: "my-endpoint",
+		Protocol:   "file-sweep",
+		SweepDir:   "/tmp/sweep",
+	}
+	if err := lc.Validate(); err == nil {
+		t.Fatal("expected error for missing addr")
+	}
+
+	lc.Addr = "https://example.com/upload"
+	if err := lc.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestListenerConfigValidateUnknownProtocol(t *testing.T) {
+	lc := ListenerConfig{
+		EndpointID: "my-endpoint",
+		Addr:       "localhost:3000",
+		Protocol:   "bogus",
+	}
+	if err := lc.Validate(); err == nil {
+		t.Fatal("expected error for unknown protocol")
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/pico/agent/config"
+	"github.com/andydunstall/pico/pkg/log"
+)
+
+const (
+	stagingDirName   = ".uploading"
+	maxUploadRetries = 5
+)
+
+// FileSweepListener periodically scans a local directory and uploads each
+// file it finds through the tunnel to the configured endpoint, deleting the
+// file once it's been uploaded successfully.
+//
+// Files are moved into a '.uploading' staging directory via an atomic
+// rename before being queued, so a file is never read from two places at
+// once and a crash mid-sweep can't lose track of a file that's already
+// been claimed: on restart anything left in the staging directory is
+// picked up again, giving at-least-once delivery.
+type FileSweepListener struct {
+	conf   config.ListenerConfig
+	client *http.Client
+	logger *log.Logger
+
+	// claimedMu guards claimed.
+	claimedMu sync.Mutex
+	// claimed holds the staging path of every file currently queued or
+	// being uploaded by a worker, so enqueueStaged doesn't re-queue a file
+	// a worker is still busy with (which would let two workers upload and
+	// remove the same path concurrently).
+	claimed map[string]struct{}
+}
+
+// NewFileSweepListener creates a directory-sweep upload listener from conf.
+func NewFileSweepListener(conf config.ListenerConfig, logger *log.Logger) *FileSweepListener {
+	return &FileSweepListener{
+		conf:    conf,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		logger:  logger.WithSubsystem("agent.filesweep"),
+		claimed: make(map[string]struct{}),
+	}
+}
+
+// tryClaim marks path as in-flight, returning false if it's already claimed.
+func (l *FileSweepListener) tryClaim(path string) bool {
+	l.claimedMu.Lock()
+	defer l.claimedMu.Unlock()
+	if _, ok := l.claimed[path]; ok {
+		return false
+	}
+	l.claimed[path] = struct{}{}
+	return true
+}
+
+// release clears path's claim, allowing it to be queued again.
+func (l *FileSweepListener) release(path string) {
+	l.claimedMu.Lock()
+	defer l.claimedMu.Unlock()
+	delete(l.claimed, path)
+}
+
+func (l *FileSweepListener) EndpointID() string { return l.conf.EndpointID }
+
+func (l *FileSweepListener) Config() config.ListenerConfig { return l.conf }
+
+// Run sweeps l.conf.SweepDir every l.conf.SweepInterval until ctx is
+// cancelled, at which point it stops claiming new files and waits for every
+// file already queued to finish uploading (or exhaust its retries) before
+// returning, so SIGTERM checkpoints cleanly rather than losing in-flight
+// work. Cancelling ctx does not interrupt an upload already queued.
+func (l *FileSweepListener) Run(ctx context.Context) error {
+	stagingDir := filepath.Join(l.conf.SweepDir, stagingDirName)
+	if err := os.MkdirAll(stagingDir, 0o755); err != nil {
+		return fmt.Errorf("create staging dir: %w", err)
+	}
+
+	// Bounded in-memory queue fed by the sweeper goroutine; workers drain
+	// it concurrently.
+	queue := make(chan string, l.conf.SweepConcurrency*4)
+
+	// Workers upload against a detached context rather than ctx, so that
+	// once a file has been claimed into the staging dir its upload runs to
+	// completion (or exhausts its retries) rather than being cut short the
+	// instant a shutdown signal arrives.
+	var wg sync.WaitGroup
+	for i := 0; i < l.conf.SweepConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.worker(context.Background(), queue)
+		}()
+	}
+
+	// Pick up anything left in the staging directory from a previous run
+	// that didn't finish uploading before it was stopped.
+	l.enqueueStaged(stagingDir, queue)
+
+	ticker := time.NewTicker(l.conf.SweepInterval)
+	defer ticker.Stop()
+
+	l.sweep(stagingDir, queue)
+	for {
+		select {
+		case <-ctx.Done():
+			close(queue)
+			wg.Wait()
+			return nil
+		case <-ticker.C:
+			l.sweep(stagingDir, queue)
+			// Also re-queue anything still left in the staging dir, such as
+			// a file that exhausted its upload retries on an earlier sweep,
+			// so it's retried rather than stuck there until the agent
+			// restarts.
+			l.enqueueStaged(stagingDir, queue)
+		}
+	}
+}
+
+// sweep moves each regular file directly under SweepDir into the staging
+// directory, then enqueues it for upload.
+func (l *FileSweepListener) sweep(stagingDir string, queue chan<- string) {
+	entries, err := os.ReadDir(l.conf.SweepDir)
+	if err != nil {
+		l.logger.Error("failed to sweep dir", zap.Error(err))
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		src := filepath.Join(l.conf.SweepDir, entry.Name())
+		dst := filepath.Join(stagingDir, entry.Name())
+
+		// Atomic rename within the same filesystem claims the file: once
+		// moved it won't be picked up by another sweep.
+		if err := os.Rename(src, dst); err != nil {
+			l.logger.Error("failed to claim file", zap.String("file", entry.Name()), zap.Error(err))
+			continue
+		}
+
+		// Freshly moved into the staging dir, so it can't already be
+		// claimed; guard anyway for symmetry with enqueueStaged.
+		if !l.tryClaim(dst) {
+			continue
+		}
+		select {
+		case queue <- dst:
+		default:
+			l.release(dst)
+			l.logger.Warn("upload queue full, will retry next sweep", zap.String("file", entry.Name()))
+		}
+	}
+}
+
+// enqueueStaged re-queues any files left in the staging directory that
+// aren't already queued or being uploaded by a worker: either left over
+// from a previous run that was stopped before they finished uploading, or
+// that exhausted their retries on an earlier sweep.
+func (l *FileSweepListener) enqueueStaged(stagingDir string, queue chan<- string) {
+	entries, err := os.ReadDir(stagingDir)
+	if err != nil {
+		l.logger.Error("failed to read staging dir", zap.Error(err))
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(stagingDir, entry.Name())
+		if !l.tryClaim(path) {
+			// Already queued or being uploaded by a worker from an
+			// earlier sweep; leave it be rather than queuing it twice.
+			continue
+		}
+		select {
+		case queue <- path:
+		default:
+			l.release(path)
+			l.logger.Warn("upload queue full, will retry next sweep", zap.String("file", entry.Name()))
+		}
+	}
+}
+
+func (l *FileSweepListener) worker(ctx context.Context, queue <-chan string) {
+	for path := range queue {
+		if err := l.uploadWithRetry(ctx, path); err != nil {
+			l.logger.Error(
+				"failed to upload file, leaving in staging dir",
+				zap.String("file", path),
+				zap.Error(err),
+			)
+			l.release(path)
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			l.logger.Error("failed to remove uploaded file", zap.String("file", path), zap.Error(err))
+		}
+		l.release(path)
+	}
+}
+
+func (l *FileSweepListener) uploadWithRetry(ctx context.Context, path string) error {
+	var lastErr error
+	for attempt := 0; attempt < maxUploadRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(1<<uint(attempt-1)) * time.Second
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		if err := l.upload(ctx, path); err != nil {
+			lastErr = err
+			l.logger.Warn(
+				"upload attempt failed",
+				zap.String("file", path),
+				zap.Int("attempt", attempt+1),
+				zap.Error(err),
+			)
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+func (l *FileSweepListener) upload(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("create form file: %w", err)
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close multipart writer: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.conf.Addr, &body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if l.conf.AuthToken != "" {
+		req.Header.Set("Piko-Auth-Token", l.conf.AuthToken)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+func testFileSweepListener(t *testing.T, addr string) *FileSweepListener {
+	conf := config.ListenerConfig{
+		EndpointID:       "test",
+		Addr:             addr,
+		Protocol:         "file-sweep",
+		SweepDir:         t.TempDir(),
+		SweepConcurrency: 1,
+	}
+	return NewFileSweepListener(conf, testLogger(t))
+}
+
+// TestFileSweepListenerSweepUploadsFile verifies a file dropped in SweepDir
+// is moved into staging, uploaded, and removed once the upload succeeds.
+func TestFileSweepListenerSweepUploadsFile(t *testing.T) {
+	var uploads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	l := testFileSweepListener(t, server.URL)
+
+	src := filepath.Join(l.conf.SweepDir, "file.txt")
+	if err := os.WriteFile(src, []byte("data"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	stagingDir := filepath.Join(l.conf.SweepDir, stagingDirName)
+	if err := os.MkdirAll(stagingDir, 0o755); err != nil {
+		t.Fatalf("mkdir staging: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := make(chan string, 1)
+	go l.worker(ctx, queue)
+
+	l.sweep(stagingDir, queue)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			if _, err := os.Stat(filepath.Join(stagingDir, "file.txt")); os.IsNotExist(err) {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for file to be uploaded and removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := uploads.Load(); got != 1 {
+		t.Fatalf("expected 1 upload, got %d", got)
+	}
+}
+
+// TestFileSweepListenerUploadRetriesThenSucceeds verifies a failed upload is
+// retried rather than abandoned after a single attempt.
+func TestFileSweepListenerUploadRetriesThenSucceeds(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	l := testFileSweepListener(t, server.URL)
+	path := filepath.Join(l.conf.SweepDir, "file.txt")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if err := l.uploadWithRetry(context.Background(), path); err != nil {
+		t.Fatalf("upload with retry: %v", err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Fatalf("expected 2 attempts, got %d", got)
+	}
+}
+
+// TestFileSweepListenerEnqueueStagedSkipsClaimedFile verifies enqueueStaged
+// doesn't re-queue a file that's already queued or being uploaded by a
+// worker, so a slow upload spanning more than one sweep interval can't be
+// picked up twice and uploaded concurrently by two workers.
+func TestFileSweepListenerEnqueueStagedSkipsClaimedFile(t *testing.T) {
+	l := testFileSweepListener(t, "http://unused")
+
+	stagingDir := filepath.Join(l.conf.SweepDir, stagingDirName)
+	if err := os.MkdirAll(stagingDir, 0o755); err != nil {
+		t.Fatalf("mkdir staging: %v", err)
+	}
+	path := filepath.Join(stagingDir, "file.txt")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	queue := make(chan string, 1)
+
+	// Simulate a worker still uploading the file from an earlier sweep.
+	if !l.tryClaim(path) {
+		t.Fatal("expected to claim unclaimed file")
+	}
+
+	l.enqueueStaged(stagingDir, queue)
+	select {
+	case p := <-queue:
+		t.Fatalf("expected claimed file not to be re-queued, got %q", p)
+	default:
+	}
+
+	// Once released (the simulated upload finishes), it's eligible again.
+	l.release(path)
+	l.enqueueStaged(stagingDir, queue)
+	select {
+	case p := <-queue:
+		if p != path {
+			t.Fatalf("expected %q, got %q", path, p)
+		}
+	default:
+		t.Fatal("expected released file to be re-queued")
+	}
+}
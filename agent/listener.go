@@ -0,0 +1,237 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/pico/agent/config"
+	"github.com/andydunstall/pico/agent/metrics"
+	"github.com/andydunstall/pico/agent/tunnel"
+	"github.com/andydunstall/pico/agent/upstream"
+	"github.com/andydunstall/pico/pkg/log"
+)
+
+var tracer = otel.Tracer("github.com/andydunstall/pico/agent")
+
+// reconnectBackoff is how long Run waits before re-registering with the
+// Pico server after the tunnel connection is lost.
+const reconnectBackoff = 5 * time.Second
+
+// Listener registers a single endpoint with the Pico server and forwards
+// incoming requests to the configured upstream.
+type Listener struct {
+	conf      config.ListenerConfig
+	serverURL string
+	upstream  upstream.Upstream
+	logger    *log.Logger
+}
+
+// NewListenerFromConfig creates a listener from a rich listener
+// configuration, selecting its upstream driver from conf.Protocol. It
+// registers with the Pico server at serverURL once run.
+func NewListenerFromConfig(conf config.ListenerConfig, serverURL string, logger *log.Logger) (*Listener, error) {
+	up, err := upstream.New(conf)
+	if err != nil {
+		return nil, fmt.Errorf("upstream: %w", err)
+	}
+	return &Listener{
+		conf:      conf,
+		serverURL: serverURL,
+		upstream:  up,
+		logger:    logger.WithSubsystem("agent.listener"),
+	}, nil
+}
+
+// EndpointID returns the endpoint ID this listener registers.
+func (l *Listener) EndpointID() string {
+	return l.conf.EndpointID
+}
+
+// Config returns the configuration this listener was created from.
+func (l *Listener) Config() config.ListenerConfig {
+	return l.conf
+}
+
+// Forward handles a single request tunnelled from the Pico server,
+// forwarding it to the upstream and writing the response to w. If the
+// upstream driver supports RoundTrip (such as HTTP and gRPC) that's used
+// directly; otherwise the driver's raw connection is piped bidirectionally.
+//
+// Forward is instrumented with Prometheus metrics and an OpenTelemetry
+// span per request; if the request carries a W3C 'traceparent' header the
+// span continues that trace rather than starting a new root.
+func (l *Listener) Forward(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "agent.forward", trace.WithAttributes(
+		attribute.String("piko.endpoint_id", l.conf.EndpointID),
+		attribute.String("piko.upstream_addr", l.conf.Addr),
+	))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	metrics.ActiveConnections.WithLabelValues(l.conf.EndpointID).Inc()
+	defer metrics.ActiveConnections.WithLabelValues(l.conf.EndpointID).Dec()
+
+	start := time.Now()
+	code := l.forward(w, r, span)
+	metrics.ForwardRequestsTotal.WithLabelValues(l.conf.EndpointID, strconv.Itoa(code)).Inc()
+	metrics.ForwardDuration.WithLabelValues(l.conf.EndpointID).Observe(time.Since(start).Seconds())
+
+	span.SetAttributes(attribute.Int("http.status_code", code))
+	if code >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(code))
+	}
+}
+
+func (l *Listener) forward(w http.ResponseWriter, r *http.Request, span trace.Span) int {
+	if rt, ok := l.upstream.(upstream.RoundTripper); ok {
+		resp, err := rt.RoundTrip(r)
+		if err != nil {
+			l.logger.Warn("failed to forward request", zap.Error(err))
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return http.StatusBadGateway
+		}
+		defer resp.Body.Close()
+
+		for k, vs := range resp.Header {
+			for _, v := range vs {
+				w.Header().Add(k, v)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		n, _ := io.Copy(w, resp.Body)
+		metrics.BytesTransferredTotal.WithLabelValues(l.conf.EndpointID, "downstream").Add(float64(n))
+		return resp.StatusCode
+	}
+
+	conn, err := l.upstream.Dial(r.Context())
+	if err != nil {
+		metrics.UpstreamDialErrorsTotal.WithLabelValues(l.conf.EndpointID).Inc()
+		l.logger.Warn("failed to dial upstream", zap.Error(err))
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	defer conn.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		l.logger.Error("response writer does not support hijacking")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		l.logger.Error("failed to hijack connection", zap.Error(err))
+		return http.StatusInternalServerError
+	}
+	defer client.Close()
+
+	l.pipe(r.Context(), client, conn)
+	return http.StatusOK
+}
+
+// pipe copies bytes bidirectionally between client and upstream, returning
+// once either side closes its connection or ctx is cancelled.
+func (l *Listener) pipe(ctx context.Context, client, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		n, _ := io.Copy(upstream, client)
+		metrics.BytesTransferredTotal.WithLabelValues(l.conf.EndpointID, "upstream").Add(float64(n))
+		done <- struct{}{}
+	}()
+	go func() {
+		n, _ := io.Copy(client, upstream)
+		metrics.BytesTransferredTotal.WithLabelValues(l.conf.EndpointID, "downstream").Add(float64(n))
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+}
+
+// Run registers the endpoint with the Pico server and forwards each request
+// pushed down that connection to the configured upstream, until ctx is
+// cancelled. If the tunnel connection is lost it's re-established after
+// reconnectBackoff, so a restart of the Pico server doesn't require
+// restarting the agent.
+func (l *Listener) Run(ctx context.Context) error {
+	l.logger.Info(
+		"starting listener",
+		zap.String("endpoint-id", l.conf.EndpointID),
+		zap.String("addr", l.conf.Addr),
+		zap.String("protocol", l.conf.Protocol),
+	)
+	defer l.logger.Info(
+		"stopped listener",
+		zap.String("endpoint-id", l.conf.EndpointID),
+	)
+
+	server := &http.Server{Handler: http.HandlerFunc(l.Forward)}
+
+	for {
+		if err := l.serveTunnel(ctx, server); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			l.logger.Warn(
+				"lost connection to pico server, reconnecting",
+				zap.String("endpoint-id", l.conf.EndpointID),
+				zap.Error(err),
+			)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(reconnectBackoff):
+			}
+			continue
+		}
+		return nil
+	}
+}
+
+// serveTunnel registers a single tunnel connection and serves requests from
+// it until the connection is lost or ctx is cancelled.
+func (l *Listener) serveTunnel(ctx context.Context, server *http.Server) error {
+	session, err := tunnel.Dial(ctx, l.serverURL, l.conf.EndpointID, l.conf.AuthToken)
+	if err != nil {
+		return fmt.Errorf("connect to pico server: %w", err)
+	}
+	defer session.Close()
+
+	l.logger.Info("registered with pico server", zap.String("endpoint-id", l.conf.EndpointID))
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+	defer close(done)
+
+	err = server.Serve(session)
+	if ctx.Err() != nil || err == nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+// Package metrics defines the Prometheus metrics exposed by the agent via
+// the admin server's '/metrics' endpoint.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Registry collects every metric registered by this package. It's kept
+// separate from the default Prometheus registry so the agent's '/metrics'
+// output only ever contains its own metrics.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// ForwardRequestsTotal counts forwarded requests by endpoint and
+	// response status code.
+	ForwardRequestsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "pico_agent_forward_requests_total",
+		Help: "Total number of requests forwarded to an upstream.",
+	}, []string{"endpoint", "code"})
+
+	// ForwardDuration observes the time taken to forward a request to an
+	// upstream and receive a response, by endpoint.
+	ForwardDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pico_agent_forward_duration_seconds",
+		Help:    "Time taken to forward a request to an upstream.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	// ActiveConnections tracks the number of forwarded connections or
+	// requests currently in flight, by endpoint.
+	ActiveConnections = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pico_agent_active_connections",
+		Help: "Number of forwarded connections currently in flight.",
+	}, []string{"endpoint"})
+
+	// UpstreamDialErrorsTotal counts failures dialing an upstream, by
+	// endpoint.
+	UpstreamDialErrorsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "pico_agent_upstream_dial_errors_total",
+		Help: "Total number of errors dialing an upstream.",
+	}, []string{"endpoint"})
+
+	// BytesTransferredTotal counts bytes forwarded between the tunnel and
+	// the upstream, by endpoint and direction ("upstream" or
+	// "downstream"). For requests forwarded via an upstream's RoundTrip
+	// (HTTP, gRPC) only the response body is counted, as "downstream".
+	BytesTransferredTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "pico_agent_bytes_transferred_total",
+		Help: "Total bytes transferred between the tunnel and the upstream.",
+	}, []string{"endpoint", "direction"})
+)
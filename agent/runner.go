@@ -0,0 +1,38 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andydunstall/pico/agent/config"
+	"github.com/andydunstall/pico/pkg/log"
+)
+
+// listenerRunner is implemented by every listener mode the agent supports
+// (forwarding listeners and the directory-sweep upload listener), so Agent
+// can manage them uniformly.
+type listenerRunner interface {
+	EndpointID() string
+	Config() config.ListenerConfig
+	Run(ctx context.Context) error
+}
+
+// newRunner creates the listenerRunner for conf, selecting the
+// implementation based on conf.Protocol. serverURL is ignored for the
+// "file-sweep" protocol, which doesn't register with the Pico server.
+func newRunner(conf config.ListenerConfig, serverURL string, logger *log.Logger) (listenerRunner, error) {
+	if conf.Protocol == "file-sweep" {
+		return NewFileSweepListener(conf, logger), nil
+	}
+
+	l, err := NewListenerFromConfig(conf, serverURL, logger)
+	if err != nil {
+		return nil, fmt.Errorf("new listener: %w", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+// Package telemetry sets up OpenTelemetry tracing for the agent, exporting
+// spans for each forwarded request via OTLP.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+// Setup configures the process-global OpenTelemetry tracer provider and
+// propagator from conf, returning a shutdown function that must be called
+// to flush and release the exporter. If conf.OTLPEndpoint is empty,
+// tracing is left disabled (the global no-op provider) and shutdown is a
+// no-op.
+func Setup(ctx context.Context, conf config.TelemetryConfig) (shutdown func(context.Context) error, err error) {
+	if conf.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(
+		ctx,
+		otlptracegrpc.WithEndpoint(conf.OTLPEndpoint),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName(conf.ServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SamplerRatio))),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	// Continue a trace from an incoming W3C 'traceparent' header rather
+	// than starting a new root span.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+// Package tunnel implements the agent side of the connection to a Pico
+// server: registering an endpoint, then accepting the requests the server
+// pushes down that connection for it.
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/yamux"
+)
+
+// Session is a registered tunnel connection for a single endpoint. Once
+// registered, the Pico server forwards each incoming request for that
+// endpoint as a new multiplexed stream on the connection, which Session
+// exposes via Accept so it can be served directly by an http.Server.
+//
+// Session implements net.Listener.
+type Session struct {
+	mux *yamux.Session
+}
+
+// Dial connects to the Pico server at serverURL and registers endpointID,
+// so the server starts forwarding requests for that endpoint down the
+// returned session.
+func Dial(ctx context.Context, serverURL, endpointID, authToken string) (*Session, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse server url: %w", err)
+	}
+
+	addr := u.Host
+	if addr == "" {
+		return nil, fmt.Errorf("missing server host")
+	}
+
+	var d net.Dialer
+	var conn net.Conn
+	switch u.Scheme {
+	case "https", "wss":
+		conn, err = d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		}
+	default:
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial server: %w", err)
+	}
+
+	if err := register(conn, endpointID, authToken); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	mux, err := yamux.Client(conn, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("multiplex session: %w", err)
+	}
+
+	return &Session{mux: mux}, nil
+}
+
+// register performs the handshake that tells the Pico server which
+// endpoint this connection is for, upgrading the connection for
+// multiplexing once the server accepts it.
+func register(conn net.Conn, endpointID, authToken string) error {
+	req, err := http.NewRequest(http.MethodConnect, "/agent/connect", nil)
+	if err != nil {
+		return fmt.Errorf("new register request: %w", err)
+	}
+	req.Header.Set("Piko-Endpoint-ID", endpointID)
+	if authToken != "" {
+		req.Header.Set("Piko-Auth-Token", authToken)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("register endpoint: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("register endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("register endpoint: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Accept returns the next request stream pushed down by the Pico server.
+func (s *Session) Accept() (net.Conn, error) {
+	return s.mux.Accept()
+}
+
+// Addr returns the tunnelled connection's local address.
+func (s *Session) Addr() net.Addr {
+	return s.mux.Addr()
+}
+
+// Close tears down the tunnel connection.
+func (s *Session) Close() error {
+	return s.mux.Close()
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+// GRPCUpstream forwards gRPC requests, routing by the fully qualified
+// service name in the request path ('/<service>/<method>') so a single
+// endpoint can multiplex multiple upstream gRPC services.
+type GRPCUpstream struct {
+	conf     config.ListenerConfig
+	services map[string]string // service name -> upstream addr
+	dialer   *net.Dialer
+
+	mu         sync.Mutex
+	transports map[string]http.RoundTripper // upstream addr -> transport
+}
+
+// NewGRPCUpstream creates a gRPC upstream driver from conf.
+func NewGRPCUpstream(conf config.ListenerConfig) (*GRPCUpstream, error) {
+	services := make(map[string]string, len(conf.GRPCServices))
+	for _, s := range conf.GRPCServices {
+		services[s.Service] = s.Addr
+	}
+
+	return &GRPCUpstream{
+		conf:       conf,
+		services:   services,
+		dialer:     &net.Dialer{Timeout: conf.DialTimeout},
+		transports: make(map[string]http.RoundTripper),
+	}, nil
+}
+
+// Dial opens a connection to the default upstream address for this
+// listener. Requests are forwarded via RoundTrip instead, which itself
+// falls back to that address when the request's service isn't in
+// GRPCServices.
+func (u *GRPCUpstream) Dial(ctx context.Context) (net.Conn, error) {
+	return u.dialer.DialContext(ctx, "tcp", u.conf.Addr)
+}
+
+// RoundTrip forwards a gRPC request, routing to the upstream address
+// registered for the request's service, falling back to the listener's
+// default address.
+func (u *GRPCUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	addr := u.addrForPath(r.URL.Path)
+
+	r = r.Clone(r.Context())
+	r.URL.Scheme = "http"
+	r.URL.Host = addr
+	r.RequestURI = ""
+
+	return u.transportFor(addr).RoundTrip(r)
+}
+
+// transportFor returns the (H2C) transport used to dial addr, creating and
+// caching one on first use.
+func (u *GRPCUpstream) transportFor(addr string) http.RoundTripper {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if t, ok := u.transports[addr]; ok {
+		return t
+	}
+
+	t := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, _ string, _ *tls.Config) (net.Conn, error) {
+			return u.dialer.DialContext(ctx, network, addr)
+		},
+	}
+	u.transports[addr] = t
+	return t
+}
+
+// addrForPath returns the upstream address serving the gRPC service named
+// in a request path of the form '/<package>.<Service>/<Method>'.
+func (u *GRPCUpstream) addrForPath(path string) string {
+	service := strings.TrimPrefix(path, "/")
+	if i := strings.LastIndex(service, "/"); i != -1 {
+		service = service[:i]
+	}
+	if addr, ok := u.services[service]; ok {
+		return addr
+	}
+	return u.conf.Addr
+}
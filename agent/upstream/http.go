@@ -0,0 +1,108 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+// HTTPUpstream forwards requests to an HTTP(S) upstream, rewriting the
+// 'Host' header and injecting configured headers before forwarding.
+type HTTPUpstream struct {
+	conf      config.ListenerConfig
+	tls       bool
+	tlsConfig *tls.Config
+	transport http.RoundTripper
+	dialer    *net.Dialer
+}
+
+// NewHTTPUpstream creates an HTTP(S) upstream driver from conf.
+func NewHTTPUpstream(conf config.ListenerConfig, useTLS bool) (*HTTPUpstream, error) {
+	var tlsConfig *tls.Config
+	if useTLS {
+		var err error
+		tlsConfig, err = buildTLSConfig(conf.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: conf.DialTimeout}
+
+	u := &HTTPUpstream{
+		conf:      conf,
+		tls:       useTLS,
+		tlsConfig: tlsConfig,
+		dialer:    dialer,
+	}
+	u.transport = &http.Transport{
+		// dialContext already completes the TLS handshake for an HTTPS
+		// upstream, so it's also used as DialTLSContext to stop the
+		// transport wrapping the connection in TLS a second time.
+		DialContext:     u.dialContext,
+		DialTLSContext:  u.dialContext,
+		TLSClientConfig: tlsConfig,
+	}
+	return u, nil
+}
+
+// Dial opens a raw connection to the upstream, used when the caller wants
+// to forward at the connection level rather than via RoundTrip.
+func (u *HTTPUpstream) Dial(ctx context.Context) (net.Conn, error) {
+	return u.dialContext(ctx, "tcp", u.conf.Addr)
+}
+
+func (u *HTTPUpstream) dialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	if u.tls {
+		return tls.DialWithDialer(u.dialer, network, u.conf.Addr, u.tlsConfig)
+	}
+	return u.dialer.DialContext(ctx, network, u.conf.Addr)
+}
+
+// RoundTrip forwards r to the upstream, rewriting the Host header and
+// injecting any configured headers.
+func (u *HTTPUpstream) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = "http"
+	if u.tls {
+		r.URL.Scheme = "https"
+	}
+	r.URL.Host = u.conf.Addr
+	r.RequestURI = ""
+
+	if u.conf.RewriteHost != "" {
+		r.Host = u.conf.RewriteHost
+	}
+	for k, v := range u.conf.Headers {
+		r.Header.Set(k, v)
+	}
+
+	return u.transport.RoundTrip(r)
+}
+
+func buildTLSConfig(conf *config.TLSConfig) (*tls.Config, error) {
+	if conf == nil {
+		return &tls.Config{}, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+	if conf.Cert != "" && conf.Key != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load keypair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package upstream
+
+import (
+	"context"
+	"net"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+// TCPUpstream forwards raw TCP connections to the upstream address, with
+// no protocol awareness.
+type TCPUpstream struct {
+	conf   config.ListenerConfig
+	dialer *net.Dialer
+}
+
+// NewTCPUpstream creates a raw TCP upstream driver from conf.
+func NewTCPUpstream(conf config.ListenerConfig) (*TCPUpstream, error) {
+	return &TCPUpstream{
+		conf:   conf,
+		dialer: &net.Dialer{Timeout: conf.DialTimeout},
+	}, nil
+}
+
+func (u *TCPUpstream) Dial(ctx context.Context) (net.Conn, error) {
+	return u.dialer.DialContext(ctx, "tcp", u.conf.Addr)
+}
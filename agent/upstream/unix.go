@@ -0,0 +1,32 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package upstream
+
+import (
+	"context"
+	"net"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+// UnixUpstream forwards connections to a Unix domain socket, where
+// ListenerConfig.Addr is the socket path.
+type UnixUpstream struct {
+	conf   config.ListenerConfig
+	dialer *net.Dialer
+}
+
+// NewUnixUpstream creates a Unix domain socket upstream driver from conf.
+func NewUnixUpstream(conf config.ListenerConfig) (*UnixUpstream, error) {
+	return &UnixUpstream{
+		conf:   conf,
+		dialer: &net.Dialer{Timeout: conf.DialTimeout},
+	}, nil
+}
+
+func (u *UnixUpstream) Dial(ctx context.Context) (net.Conn, error) {
+	return u.dialer.DialContext(ctx, "unix", u.conf.Addr)
+}
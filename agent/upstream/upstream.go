@@ -0,0 +1,52 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+// Package upstream defines the pluggable drivers used by the agent to
+// forward traffic to an upstream service. Each listener selects a driver
+// via its 'protocol' configuration field.
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+// Upstream dials a connection to the configured upstream service.
+//
+// Drivers that forward at the connection level (TCP, Unix) only need to
+// implement Dial. Drivers that forward at the request level (HTTP, gRPC) also
+// implement RoundTripper, which the listener prefers when available since
+// it lets the driver rewrite the request rather than just piping bytes.
+type Upstream interface {
+	Dial(ctx context.Context) (net.Conn, error)
+}
+
+// RoundTripper is implemented by upstream drivers that forward at the HTTP
+// request level rather than the raw connection level.
+type RoundTripper interface {
+	RoundTrip(r *http.Request) (*http.Response, error)
+}
+
+// New creates the upstream driver selected by conf.Protocol.
+func New(conf config.ListenerConfig) (Upstream, error) {
+	switch conf.Protocol {
+	case "", "http":
+		return NewHTTPUpstream(conf, false)
+	case "https":
+		return NewHTTPUpstream(conf, true)
+	case "tcp":
+		return NewTCPUpstream(conf)
+	case "unix":
+		return NewUnixUpstream(conf)
+	case "grpc":
+		return NewGRPCUpstream(conf)
+	default:
+		return nil, fmt.Errorf("unknown upstream protocol: %s", conf.Protocol)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func newCompletionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:       "completion [bash|zsh|fish]",
+		Short:     "generate shell completion scripts",
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish"},
+		Long: `Generate a shell completion script for the pico agent CLI.
+
+Examples:
+  # Load completions for the current bash session.
+  source <(pico agent completion bash)
+
+  # Persist zsh completions.
+  pico agent completion zsh > "${fpath[1]}/_pico"
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				_ = root.GenBashCompletion(os.Stdout)
+			case "zsh":
+				_ = root.GenZshCompletion(os.Stdout)
+			case "fish":
+				_ = root.GenFishCompletion(os.Stdout, true)
+			}
+		},
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+const defaultAdminAddr = "127.0.0.1:9901"
+
+func newListenersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "listeners",
+		Short: "add, remove and list the listeners registered with a running agent",
+		Long: `Manage the listeners registered with an already running agent, by calling
+its local admin API.
+`,
+	}
+
+	cmd.AddCommand(newListenersListCommand())
+	cmd.AddCommand(newListenersAddCommand())
+	cmd.AddCommand(newListenersRemoveCommand())
+
+	return cmd
+}
+
+func newListenersListCommand() *cobra.Command {
+	var adminAddr string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list the listeners registered with a running agent",
+		Run: func(cmd *cobra.Command, args []string) {
+			b, err := adminRequest(adminAddr, http.MethodGet, "/agent/listeners", nil)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			os.Stdout.Write(b)
+		},
+	}
+	cmd.Flags().StringVar(&adminAddr, "admin.addr", defaultAdminAddr, "address of the running agent's admin server")
+	return cmd
+}
+
+func newListenersAddCommand() *cobra.Command {
+	var adminAddr string
+	var lc config.ListenerConfig
+
+	cmd := &cobra.Command{
+		Use:     "add [flags]",
+		Short:   "register a new listener with a running agent",
+		Example: `  pico agent listeners add --endpoint-id my-endpoint-123 --addr localhost:3000`,
+		Run: func(cmd *cobra.Command, args []string) {
+			body, err := json.Marshal(&lc)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			if _, err := adminRequest(adminAddr, http.MethodPost, "/agent/listeners", body); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("registered listener: %s\n", lc.EndpointID)
+		},
+	}
+	cmd.Flags().StringVar(&adminAddr, "admin.addr", defaultAdminAddr, "address of the running agent's admin server")
+	cmd.Flags().StringVar(&lc.EndpointID, "endpoint-id", "", "endpoint ID to register")
+	cmd.Flags().StringVar(&lc.Addr, "addr", "", "upstream address to forward requests to")
+	cmd.Flags().StringVar(&lc.Protocol, "protocol", "http", "upstream protocol")
+	return cmd
+}
+
+func newListenersRemoveCommand() *cobra.Command {
+	var adminAddr string
+
+	cmd := &cobra.Command{
+		Use:   "remove <endpoint-id>",
+		Short: "stop a listener registered with a running agent",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "/agent/listeners?endpoint_id=" + url.QueryEscape(args[0])
+			if _, err := adminRequest(adminAddr, http.MethodDelete, path, nil); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("removed listener: %s\n", args[0])
+		},
+	}
+	cmd.Flags().StringVar(&adminAddr, "admin.addr", defaultAdminAddr, "address of the running agent's admin server")
+	return cmd
+}
+
+// adminRequest calls the admin API of a running agent at adminAddr,
+// returning an error describing the response body if the call didn't
+// succeed.
+func adminRequest(adminAddr, method, path string, body []byte) ([]byte, error) {
+	fullURL := fmt.Sprintf("http://%s%s", adminAddr, path)
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, fullURL, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request agent admin API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("agent returned %d: %s", resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
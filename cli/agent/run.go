@@ -0,0 +1,240 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/pico/agent"
+	"github.com/andydunstall/pico/agent/config"
+	"github.com/andydunstall/pico/agent/telemetry"
+	"github.com/andydunstall/pico/pkg/log"
+)
+
+func newRunCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run [flags]",
+		Short: "run the pico agent",
+		Long: `Run the Pico agent.
+
+The Pico agent is a CLI that runs alongside your upstream service that
+registers one or more listeners.
+
+The agent will connect to a Pico server, register the configured listeners,
+then forwards incoming requests to your upstream service.
+
+Such as if you have a service running at 'localhost:3000', you can register
+endpoint 'my-endpoint' that forwards requests to that local service.
+
+Examples:
+  # Register a listener with endpoint ID 'my-endpoint-123' that forwards
+  # requests to 'localhost:3000'.
+  pico agent run --listeners my-endpoint-123/localhost:3000
+
+  # Register multiple listeners.
+  pico agent run --listeners my-endpoint-123/localhost:3000 \
+      --listeners my-endpoint-xyz/localhost:6000
+
+  # Specify the Pico server address.
+  pico agent run --listeners my-endpoint-123/localhost:3000 \
+      --server.url https://pico.example.com
+
+  # Load configuration from a YAML file, with hot reload on change.
+  pico agent run --config.file ./agent.yaml
+`,
+	}
+
+	var conf config.Config
+	var flags config.Config
+
+	addConfigFlags(cmd, &conf, &flags)
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		if err := loadAndValidate(&conf, &flags); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		logger, err := log.NewLogger(conf.Log.Level, conf.Log.Subsystems)
+		if err != nil {
+			fmt.Printf("failed to setup logger: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		run(&conf, &flags, logger)
+	}
+
+	return cmd
+}
+
+// addConfigFlags registers the flags shared by 'agent run' and
+// 'agent validate': '--config.file' populates conf directly, while the
+// rest populate flags so they can be merged over the file with CLI flags
+// taking precedence.
+func addConfigFlags(cmd *cobra.Command, conf *config.Config, flags *config.Config) {
+	cmd.Flags().StringVar(&conf.ConfigFile, "config.file", "", "path to YAML configuration file")
+	cmd.Flags().StringSliceVar(&flags.Listeners, "listeners", nil, "command separated listeners to register, with format '<endpoint ID>/<forward addr>'")
+
+	cmd.Flags().StringVar(&flags.Server.URL, "server.url", "", "address of the pico server to register listeners with")
+
+	cmd.Flags().StringVar(&flags.Log.Level, "log.level", "", "log level")
+	cmd.Flags().StringSliceVar(&flags.Log.Subsystems, "log.subsystems", nil, "enable debug logs for logs the the given subsystems")
+
+	cmd.Flags().StringVar(&flags.Admin.BindAddr, "admin.bind-addr", "", "address to listen for admin connections, such as '/agent/monitor' (default '127.0.0.1:9901')")
+}
+
+// loadAndValidate loads conf.ConfigFile (if set), merges flags over it
+// (CLI flags take precedence), and validates the result in place.
+func loadAndValidate(conf *config.Config, flags *config.Config) error {
+	if conf.ConfigFile != "" {
+		fileConf, err := config.Load(conf.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config file: %w", err)
+		}
+		*conf = *fileConf
+	}
+	conf.Merge(flags)
+
+	if conf.Log.Level == "" {
+		conf.Log.Level = "info"
+	}
+
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
+
+// run starts the agent and blocks until it receives a shutdown signal. If
+// the agent was started with '--config.file', changes to the file (either
+// an fsnotify event or a SIGHUP) trigger a reconcile of the running
+// listeners rather than a restart, so unrelated connections aren't dropped.
+func run(conf *config.Config, flags *config.Config, logger *log.Logger) {
+	logger.Info("starting pico agent", zap.Any("conf", conf))
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracingShutdown, err := telemetry.Setup(ctx, conf.Telemetry)
+	if err != nil {
+		logger.Error("failed to setup telemetry", zap.Error(err))
+		os.Exit(1)
+	}
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := tracingShutdown(shutdownCtx); err != nil {
+			logger.Error("failed to shutdown telemetry", zap.Error(err))
+		}
+	}()
+
+	a := agent.NewAgent(logger)
+	defer a.Close()
+
+	if err := a.Reconcile(ctx, conf); err != nil {
+		logger.Error("failed to start listeners", zap.Error(err))
+		os.Exit(1)
+	}
+
+	admin := agent.NewAdminServer(conf.Admin.BindAddr, a, logger)
+	adminDone := make(chan error, 1)
+	go func() {
+		adminDone <- admin.Run(ctx)
+	}()
+	go func() {
+		if err := <-adminDone; err != nil {
+			logger.Error("admin server exited", zap.Error(err))
+		}
+	}()
+
+	var watcher *fsnotify.Watcher
+	if conf.ConfigFile != "" {
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			logger.Error("failed to watch config file", zap.Error(err))
+			os.Exit(1)
+		}
+		defer watcher.Close()
+
+		if err := watcher.Add(conf.ConfigFile); err != nil {
+			logger.Error("failed to watch config file", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP && conf.ConfigFile != "" {
+				reload(ctx, conf, flags, logger, a)
+				continue
+			}
+
+			logger.Info("received shutdown signal", zap.String("signal", sig.String()))
+			cancel()
+			logger.Info("shutdown complete")
+			return
+		case event := <-watcherEvents(watcher):
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				reload(ctx, conf, flags, logger, a)
+			}
+		case err := <-watcherErrors(watcher):
+			logger.Error("config file watch error", zap.Error(err))
+		}
+	}
+}
+
+// reload re-reads the configuration file, re-validates it, and reconciles
+// the running listeners to match. A bad config file is logged and ignored
+// so a typo doesn't take down an otherwise healthy agent.
+func reload(ctx context.Context, conf *config.Config, flags *config.Config, logger *log.Logger, a *agent.Agent) {
+	logger.Info("reloading config", zap.String("file", conf.ConfigFile))
+
+	fileConf, err := config.Load(conf.ConfigFile)
+	if err != nil {
+		logger.Error("failed to reload config", zap.Error(err))
+		return
+	}
+	fileConf.Merge(flags)
+
+	if err := fileConf.Validate(); err != nil {
+		logger.Error("invalid config, ignoring reload", zap.Error(err))
+		return
+	}
+
+	*conf = *fileConf
+
+	if err := a.Reconcile(ctx, conf); err != nil {
+		logger.Error("failed to reconcile listeners", zap.Error(err))
+	}
+}
+
+func watcherEvents(w *fsnotify.Watcher) chan fsnotify.Event {
+	if w == nil {
+		return nil
+	}
+	return w.Events
+}
+
+func watcherErrors(w *fsnotify.Watcher) chan error {
+	if w == nil {
+		return nil
+	}
+	return w.Errors
+}
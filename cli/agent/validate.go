@@ -0,0 +1,52 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andydunstall/pico/agent/config"
+)
+
+func newValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate [flags]",
+		Short: "validate the agent configuration",
+		Long: `Parse and validate the agent configuration, without starting the agent.
+
+Exits with a non-zero status and prints the error if the configuration is
+invalid, otherwise prints the resolved configuration as JSON.
+
+Examples:
+  pico agent validate --config.file ./agent.yaml
+`,
+	}
+
+	var conf config.Config
+	var flags config.Config
+
+	addConfigFlags(cmd, &conf, &flags)
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		if err := loadAndValidate(&conf, &flags); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(&conf); err != nil {
+			fmt.Printf("failed to encode config: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	return cmd
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package log
+
+import (
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// sinkRegistry is the set of sinks subscribed to a logger, shared by the
+// root fanoutCore and every core derived from it via With(), so a sink
+// subscribed on the root sees entries logged by any subsystem logger.
+type sinkRegistry struct {
+	mu    sync.Mutex
+	sinks []*Sink
+}
+
+func (r *sinkRegistry) add(s *Sink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sinks = append(r.sinks, s)
+}
+
+func (r *sinkRegistry) remove(s *Sink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, sink := range r.sinks {
+		if sink == s {
+			r.sinks = append(r.sinks[:i], r.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *sinkRegistry) snapshot() []*Sink {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sinks := make([]*Sink, len(r.sinks))
+	copy(sinks, r.sinks)
+	return sinks
+}
+
+// fanoutCore is a zapcore.Core that writes every log entry to any sinks
+// currently subscribed via Logger.AddSink, in addition to whatever core it
+// wraps. This lets operators attach to a running agent's live log output
+// (such as via the '/agent/monitor' endpoint) without affecting the
+// process's normal logging.
+type fanoutCore struct {
+	zapcore.Core
+
+	encoder  zapcore.Encoder
+	registry *sinkRegistry
+}
+
+func newFanoutCore(core zapcore.Core, encoder zapcore.Encoder) *fanoutCore {
+	return &fanoutCore{
+		Core:     core,
+		encoder:  encoder,
+		registry: &sinkRegistry{},
+	}
+}
+
+func (c *fanoutCore) With(fields []zapcore.Field) zapcore.Core {
+	return &fanoutCore{
+		Core:     c.Core.With(fields),
+		encoder:  c.encoder,
+		registry: c.registry,
+	}
+}
+
+// Enabled reports whether an entry at lvl should be written at all, either
+// to the wrapped core or to a currently subscribed sink. A sink added via
+// AddSink may ask for a lower level than the logger itself was configured
+// with (such as a '/agent/monitor?level=debug' subscriber on an agent
+// running at 'info'), so it isn't enough to defer to the wrapped core here.
+func (c *fanoutCore) Enabled(lvl zapcore.Level) bool {
+	if c.Core.Enabled(lvl) {
+		return true
+	}
+	for _, s := range c.registry.snapshot() {
+		if lvl >= s.level {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *fanoutCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *fanoutCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	// Only write to the wrapped core if it would have accepted the entry
+	// itself; Enabled may have let a lower-level entry through purely for a
+	// subscribed sink's benefit.
+	if c.Core.Enabled(ent.Level) {
+		if err := c.Core.Write(ent, fields); err != nil {
+			return err
+		}
+	}
+
+	sinks := c.registry.snapshot()
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	buf, err := c.encoder.EncodeEntry(ent, fields)
+	if err != nil {
+		return nil
+	}
+	defer buf.Free()
+
+	for _, s := range sinks {
+		if ent.Level < s.level {
+			continue
+		}
+		s.write(buf.Bytes())
+	}
+	return nil
+}
+
+func (c *fanoutCore) addSink(level zapcore.Level) *Sink {
+	s := newSink(c.registry, level)
+	c.registry.add(s)
+	return s
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package log
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// TestAddSinkBelowBaseLevel verifies a sink subscribed at a lower level than
+// the logger was configured with still receives entries below that level,
+// as documented on AddSink.
+func TestAddSinkBelowBaseLevel(t *testing.T) {
+	logger, err := NewLogger("info", nil)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+
+	sink, err := logger.AddSink("debug")
+	if err != nil {
+		t.Fatalf("add sink: %v", err)
+	}
+	defer sink.Close()
+
+	logger.Debug("debug message")
+
+	select {
+	case line := <-sink.C():
+		if len(line) == 0 {
+			t.Fatal("expected non-empty log line")
+		}
+	default:
+		t.Fatal("expected debug entry to reach sink subscribed at debug level")
+	}
+}
+
+// TestAddSinkDoesNotLowerBaseLevel verifies subscribing a sink at a lower
+// level doesn't cause the base logger's own output to start including
+// entries below its configured level.
+func TestAddSinkDoesNotLowerBaseLevel(t *testing.T) {
+	logger, err := NewLogger("info", nil)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+
+	sink, err := logger.AddSink("debug")
+	if err != nil {
+		t.Fatalf("add sink: %v", err)
+	}
+	defer sink.Close()
+
+	if logger.fanout.Core.Enabled(zapcore.DebugLevel) {
+		t.Fatal("wrapped core should not be enabled for debug when configured at info")
+	}
+}
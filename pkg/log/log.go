@@ -0,0 +1,95 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+// Package log provides a thin wrapper around zap used throughout Pico, with
+// support for enabling debug logs for individual subsystems.
+package log
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logger wraps a zap.Logger to support per-subsystem debug logging and
+// attaching runtime log sinks (see AddSink).
+type Logger struct {
+	logger     *zap.Logger
+	subsystems map[string]struct{}
+	fanout     *fanoutCore
+}
+
+// NewLogger creates a logger with the given level, enabling debug logs for
+// any of the named subsystems regardless of level.
+func NewLogger(level string, subsystems []string) (*Logger, error) {
+	var zapLevel zapcore.Level
+	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+
+	config := zap.NewProductionConfig()
+	config.Level = zap.NewAtomicLevelAt(zapLevel)
+	config.EncoderConfig.TimeKey = "timestamp"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	encoder := zapcore.NewJSONEncoder(config.EncoderConfig)
+	var fanout *fanoutCore
+	logger, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		fanout = newFanoutCore(core, encoder)
+		return fanout
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("build logger: %w", err)
+	}
+
+	subsystemSet := make(map[string]struct{}, len(subsystems))
+	for _, s := range subsystems {
+		subsystemSet[s] = struct{}{}
+	}
+
+	return &Logger{
+		logger:     logger,
+		subsystems: subsystemSet,
+		fanout:     fanout,
+	}, nil
+}
+
+// AddSink subscribes to the logger's live output at the given level,
+// regardless of the level the logger itself was configured with. The
+// returned sink must be closed once the subscriber is done.
+func (l *Logger) AddSink(level string) (*Sink, error) {
+	var zapLevel zapcore.Level
+	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	return l.fanout.addSink(zapLevel), nil
+}
+
+// WithSubsystem returns a logger scoped to the given subsystem, enabling
+// debug logs for it if it was passed to NewLogger.
+func (l *Logger) WithSubsystem(subsystem string) *Logger {
+	logger := l.logger.With(zap.String("subsystem", subsystem))
+	if _, ok := l.subsystems[subsystem]; ok {
+		logger = logger.WithOptions(zap.IncreaseLevel(zapcore.DebugLevel))
+	}
+	return &Logger{
+		logger:     logger,
+		subsystems: l.subsystems,
+		// Subscriptions are tracked on the root fanout core, so sinks
+		// added via any subsystem logger see every subsystem's output.
+		fanout: l.fanout,
+	}
+}
+
+func (l *Logger) Debug(msg string, fields ...zap.Field) { l.logger.Debug(msg, fields...) }
+func (l *Logger) Info(msg string, fields ...zap.Field)  { l.logger.Info(msg, fields...) }
+func (l *Logger) Warn(msg string, fields ...zap.Field)  { l.logger.Warn(msg, fields...) }
+func (l *Logger) Error(msg string, fields ...zap.Field) { l.logger.Error(msg, fields...) }
+
+// Sync flushes any buffered log entries.
+func (l *Logger) Sync() error {
+	return l.logger.Sync()
+}
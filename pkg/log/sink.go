@@ -0,0 +1,85 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package log
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// sinkBufferSize is the number of log lines buffered per sink before lines
+// are dropped to avoid a slow subscriber blocking the rest of the agent.
+const sinkBufferSize = 1024
+
+// Sink is a subscription to the logger's live output, such as used by the
+// agent's '/agent/monitor' endpoint to stream logs to a remote client.
+//
+// Sink is backed by a bounded ring buffer: if the subscriber falls behind,
+// the oldest buffered lines are dropped rather than blocking the logger.
+type Sink struct {
+	registry *sinkRegistry
+	level    zapcore.Level
+	ch       chan []byte
+
+	// mu guards dropped and the drop-oldest/refill sequence in write, which
+	// fanoutCore.Write may call concurrently from any goroutine that logs.
+	mu      sync.Mutex
+	dropped int
+}
+
+func newSink(registry *sinkRegistry, level zapcore.Level) *Sink {
+	return &Sink{
+		registry: registry,
+		level:    level,
+		ch:       make(chan []byte, sinkBufferSize),
+	}
+}
+
+// C returns the channel of encoded log lines for this sink.
+func (s *Sink) C() <-chan []byte {
+	return s.ch
+}
+
+// Close unsubscribes the sink from the logger. It is safe to call multiple
+// times.
+func (s *Sink) Close() {
+	s.registry.remove(s)
+}
+
+func (s *Sink) write(line []byte) {
+	// Copy since the encoder buffer is reused after this call returns.
+	b := make([]byte, len(line))
+	copy(b, line)
+
+	select {
+	case s.ch <- b:
+		return
+	default:
+	}
+
+	// Subscriber has fallen behind: drop the oldest buffered line to make
+	// room, and note how many lines have been dropped so far. fanoutCore.Write
+	// may call write from multiple goroutines concurrently, so the
+	// drop-oldest/refill sequence and the counter both need the lock.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropped++
+	select {
+	case <-s.ch:
+	default:
+	}
+	select {
+	case s.ch <- []byte(droppedMarker(s.dropped)):
+	default:
+	}
+}
+
+func droppedMarker(n int) string {
+	return fmt.Sprintf(`{"level":"warn","msg":"log lines dropped","dropped":%d}`+"\n", n)
+}
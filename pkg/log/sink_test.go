@@ -0,0 +1,32 @@
+// Copyright 2024 Andrew Dunstall. All rights reserved.
+//
+// Use of this source code is governed by a MIT style license that can be
+// found in the LICENSE file.
+
+package log
+
+import (
+	"sync"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// TestSinkWriteConcurrent exercises write from many goroutines at once, so
+// that 'go test -race' catches a regression of the data race on dropped and
+// the drop-oldest/refill sequence.
+func TestSinkWriteConcurrent(t *testing.T) {
+	registry := &sinkRegistry{}
+	s := newSink(registry, zapcore.InfoLevel)
+
+	var wg sync.WaitGroup
+	// Write more lines than the buffer holds so the drop-oldest path runs.
+	for i := 0; i < sinkBufferSize*2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.write([]byte("line\n"))
+		}()
+	}
+	wg.Wait()
+}